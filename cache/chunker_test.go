@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// chunkHashSet rozseká payload a vrátí množinu hashů jeho chunků – pro
+// porovnání dvou verzí payloadu stačí spočítat, kolik hashů nové množiny
+// chybí ve staré (viz changedChunkCount), aby bylo vidět, kolik chunků je
+// potřeba reuploadovat.
+func chunkHashSet(payload []byte) map[[32]byte]struct{} {
+	chunks := chunkPayload(payload)
+	set := make(map[[32]byte]struct{}, len(chunks))
+	for _, c := range chunks {
+		set[hashChunk(c)] = struct{}{}
+	}
+	return set
+}
+
+// changedChunkCount spočítá, kolik chunků z "after" není obsaženo v
+// "before" – tedy kolik chunků by bylo potřeba skutečně přenést po změně.
+func changedChunkCount(before, after map[[32]byte]struct{}) int {
+	changed := 0
+	for hash := range after {
+		if _, ok := before[hash]; !ok {
+			changed++
+		}
+	}
+	return changed
+}
+
+// TestFetchAndCacheItemReusesUnchangedChunks odpovídá požadavku chunk0-4:
+// ověřuje, že je chunkovaný resync skutečně zapojený do reálné cesty
+// klienta (fetchAndCacheItem), ne jen v izolovaném benchmarku. Po posunu
+// bajtů na začátku payloadu (viz shiftItemPayload) musí klient z lokální
+// cache chunků znovu použít většinu starých chunků a od serveru si dotáhnout
+// jen hrstku nových.
+func TestFetchAndCacheItemReusesUnchangedChunks(t *testing.T) {
+	s := NewServer(1, defaultSubscriberLagThreshold)
+	localChunks := make(map[[32]byte][]byte)
+
+	if err := s.shiftItemPayload(0); err != nil {
+		t.Fatalf("první shiftItemPayload selhal: %v", err)
+	}
+	first, err := fetchAndCacheItem(s, 0, localChunks)
+	if err != nil {
+		t.Fatalf("fetchAndCacheItem selhal: %v", err)
+	}
+	chunksAfterFirstFetch := len(localChunks)
+
+	if err := s.shiftItemPayload(0); err != nil {
+		t.Fatalf("druhý shiftItemPayload selhal: %v", err)
+	}
+	second, err := fetchAndCacheItem(s, 0, localChunks)
+	if err != nil {
+		t.Fatalf("fetchAndCacheItem selhal: %v", err)
+	}
+
+	if len(second.Payload) != len(first.Payload)+payloadShiftSize {
+		t.Fatalf("payload po posunu má %d bajtů, čekalo se %d", len(second.Payload), len(first.Payload)+payloadShiftSize)
+	}
+
+	newChunks := len(localChunks) - chunksAfterFirstFetch
+	if newChunks > 2 {
+		t.Fatalf("posun payloadu o %d bajtů vyžádal %d nových chunků v lokální cache, čekal se ~1", payloadShiftSize, newChunks)
+	}
+}
+
+// BenchmarkChunkPayloadByteShift demonduje klíčovou vlastnost
+// content-defined chunkingu z požadavku chunk0-4: posun bajtů na začátku
+// ~1MB payloadu (vložení pár bajtů) musí vynutit reupload jen chunku (či
+// dvou) kolem místa vložení, ne celého payloadu – protože content-defined
+// hranice se po posunu znovu ustálí na stejných obsahových vzorech.
+func BenchmarkChunkPayloadByteShift(b *testing.B) {
+	const payloadSize = 1 << 20 // 1 MiB
+
+	r := rand.New(rand.NewSource(42))
+	original := make([]byte, payloadSize)
+	r.Read(original)
+
+	shifted := make([]byte, 0, payloadSize+8)
+	shifted = append(shifted, original[:64]...)
+	shifted = append(shifted, []byte{1, 2, 3, 4, 5, 6, 7, 8}...) // vložení 8 bajtů
+	shifted = append(shifted, original[64:]...)
+
+	beforeChunks := chunkPayload(original)
+	b.ReportMetric(float64(len(beforeChunks)), "chunks/payload")
+
+	b.ResetTimer()
+	var changed int
+	for i := 0; i < b.N; i++ {
+		before := chunkHashSet(original)
+		after := chunkHashSet(shifted)
+		changed = changedChunkCount(before, after)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(changed), "changed-chunks")
+	if changed > 2 {
+		b.Fatalf("posun 8 bajtů na začátku 1MB payloadu vyžádal reupload %d chunků, čekal se ~1", changed)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Leadership popisuje aktuální stav vedení, jak jej hlásí Elector na
+// kanálu vráceném z Campaign.
+type Leadership struct {
+	IsLeader bool
+}
+
+// Elector umožňuje instanci Server ucházet se o roli lídra clusteru.
+// Lídr jako jediný provádí randomEdit a rozesílá notifikace, followeři
+// jen obsluhují čtení. Implementace musí hlídat leasy/TTL sama – Server
+// pouze reaguje na zprávy na kanálu.
+type Elector interface {
+	// Campaign se uchází o vedení a vrací kanál, na který přichází
+	// změna stavu (získání i ztráta vedení). Kanál se zavírá při Resign
+	// nebo zrušení ctx.
+	Campaign(ctx context.Context) (<-chan Leadership, error)
+	// Resign se dobrovolně vzdá vedení (pokud je držíme) a uvolní kanál.
+	Resign()
+}
+
+// Lock umožňuje vyžádat si výhradní právo k editaci jedné položky napříč
+// clusterem, aby dva uzly nemohly stejné ID editovat současně.
+type Lock interface {
+	Acquire(ctx context.Context, id int) error
+	Release(id int) error
+}
+
+// InMemoryElector je jednoprocesová implementace Elector pro testy a pro
+// běh s jediným Server instance – o vedení se nemá s kým přetahovat, takže
+// jej kampaň získá okamžitě a drží až do Resign.
+type InMemoryElector struct {
+	mu      sync.Mutex
+	leading bool
+	ch      chan Leadership
+}
+
+// NewInMemoryElector vytvoří elektora, který vede sám sebe.
+func NewInMemoryElector() *InMemoryElector {
+	return &InMemoryElector{}
+}
+
+func (e *InMemoryElector) Campaign(ctx context.Context) (<-chan Leadership, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ch != nil {
+		return nil, fmt.Errorf("cluster: kampaň už probíhá")
+	}
+	e.ch = make(chan Leadership, 1)
+	e.leading = true
+	e.ch <- Leadership{IsLeader: true}
+
+	go func() {
+		<-ctx.Done()
+		e.Resign()
+	}()
+
+	return e.ch, nil
+}
+
+func (e *InMemoryElector) Resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ch == nil {
+		return
+	}
+	if e.leading {
+		e.ch <- Leadership{IsLeader: false}
+	}
+	close(e.ch)
+	e.ch = nil
+	e.leading = false
+}
+
+// InMemoryLock je sdílená implementace Lock nad jednoduchou mapou – hodí
+// se pro testy i pro jediný proces, kde "cluster" tvoří jen jeden uzel.
+type InMemoryLock struct {
+	mu     sync.Mutex
+	holder map[int]struct{}
+}
+
+// NewInMemoryLock vytvoří prázdný zámkový registr.
+func NewInMemoryLock() *InMemoryLock {
+	return &InMemoryLock{holder: make(map[int]struct{})}
+}
+
+func (l *InMemoryLock) Acquire(ctx context.Context, id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, held := l.holder[id]; held {
+		return fmt.Errorf("cluster: položka %d je již zamčená", id)
+	}
+	l.holder[id] = struct{}{}
+	return nil
+}
+
+func (l *InMemoryLock) Release(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.holder, id)
+	return nil
+}
+
+// LeaseKV je rozhraní nad úložištěm s podporou leasů (to, co v reálném
+// nasazení poskytuje etcd přes clientv3 – Lease/Txn API). EtcdLeaseKV níže
+// je tenký adaptér nad clientv3.Client, který jej implementuje; KVElector
+// pak nad libovolným LeaseKV obstarává kampaň, pravidelnou obnovu leasu a
+// detekci jeho ztráty.
+type LeaseKV interface {
+	// TryPut atomicky zapíše value pod key s daným TTL, pokud klíč
+	// neexistuje, jeho lease vypršel, nebo jej už drží stejná value (pak
+	// jde o obnovu leasu, ne o nové vítězství). Vrací true, pokud po
+	// volání key patří nám.
+	TryPut(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Delete uvolní klíč, pokud mu patří.
+	Delete(ctx context.Context, key string) error
+}
+
+// KVElector je implementace Elector nad libovolným LeaseKV úložištěm
+// (etcd, Consul, ...). Na rozdíl od jednorázového TryPut v Campaign drží
+// run() na pozadí smyčku, která lease pravidelně obnovuje (renewInterval =
+// ttl/3, obdoba etcd lease keepalive) a při každém tiku zjišťuje, zda jsme
+// o vedení nepřišli – ztrátu i získání vedení hlásí na vrácený kanál. Bez
+// reálného watch streamu (ten poskytuje jen skutečný etcd klient) je toto
+// polling, ale chová se stejně: follower se vedení ujme nejpozději do
+// jednoho renewInterval poté, co lídr svůj klíč uvolní nebo mu vyprší TTL.
+type KVElector struct {
+	kv  LeaseKV
+	key string
+	id  string
+	ttl time.Duration
+
+	mu     sync.Mutex
+	ch     chan Leadership
+	cancel context.CancelFunc
+}
+
+// NewKVElector vytvoří elektora, který o klíč key soupeří s ostatními
+// uzly pomocí zadaného LeaseKV úložiště. ttl je doba platnosti leasu;
+// elektor jej obnovuje v intervalu ttl/3.
+func NewKVElector(kv LeaseKV, key, nodeID string, ttl time.Duration) *KVElector {
+	return &KVElector{kv: kv, key: key, id: nodeID, ttl: ttl}
+}
+
+func (e *KVElector) Campaign(ctx context.Context) (<-chan Leadership, error) {
+	e.mu.Lock()
+	if e.ch != nil {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("cluster: kampaň o klíč %q už probíhá", e.key)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	ch := make(chan Leadership, 1)
+	e.ch = ch
+	e.cancel = cancel
+	e.mu.Unlock()
+
+	go e.run(runCtx, ch)
+	return ch, nil
+}
+
+// run obnovuje lease v intervalu ttl/3, dokud ctx neskončí (zrušením
+// zvenčí nebo přes Resign). Při každé změně stavu vedení (získání i
+// ztráta) pošle aktuální Leadership na ch; při ukončení uvolní klíč, pokud
+// jsme jej drželi, a kanál zavře.
+func (e *KVElector) run(ctx context.Context, ch chan Leadership) {
+	renewInterval := e.ttl / 3
+	if renewInterval <= 0 {
+		renewInterval = time.Millisecond
+	}
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	leading := false
+	for {
+		won, err := e.kv.TryPut(ctx, e.key, e.id, e.ttl)
+		if err == nil && won != leading {
+			leading = won
+			select {
+			case ch <- Leadership{IsLeader: leading}:
+			case <-ctx.Done():
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if leading {
+				_ = e.kv.Delete(context.Background(), e.key)
+			}
+			e.mu.Lock()
+			e.ch = nil
+			e.cancel = nil
+			e.mu.Unlock()
+			close(ch)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *KVElector) Resign() {
+	e.mu.Lock()
+	cancel := e.cancel
+	e.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -1,32 +1,77 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"net/rpc"
 	"sync"
 	"time"
 )
 
-// Item reprezentuje datovou položku.
+// Item reprezentuje datovou položku. Payload je volitelný – pokud je
+// neprázdný, server jej interně drží rozsekaný na chunky (viz chunker.go)
+// a GetItemManifest/GetChunk umožňují klientovi dotáhnout jen ty kusy,
+// které se od poslední synchronizace skutečně změnily.
 type Item struct {
 	ID        int
 	Value     int
+	Payload   []byte
 	UpdatedAt time.Time
 }
 
 // Server spravuje globální stav a notifikace.
 type Server struct {
-	items      map[int]*Item
-	mu         sync.RWMutex
-	subs       []chan []int // klientské kanály pro notifikace ID
-	lastNotify time.Time    // čas posledního odeslání notifikace
+	items                  map[int]*Item
+	mu                     sync.RWMutex
+	subs                   []*subscriber // odběratelé notifikací o změnách ID
+	lastNotify             time.Time     // čas posledního odeslání notifikace
+	subscriberLagThreshold int           // viz defaultSubscriberLagThreshold v subscribe.go
+
+	merkleNodes   [merkleNodeCount][32]byte // Merkle strom nad items, index 0 = kořen
+	merkleLeafIDs [merkleLeafCount][]int    // rozdělení ID položek do listů stromu
+
+	elector   Elector            // rozhoduje, zda tato instance vede randomEdit/notifikace
+	lock      Lock               // zámky na jednotlivé položky napříč clusterem
+	runCtx    context.Context    // životnost kampaně o vedení, zrušena v Stop
+	runCancel context.CancelFunc // zruší runCtx – viz Stop
+
+	nodeID      NodeID                  // identita tohoto uzlu v clusteru
+	replication Replication             // kdo vlastní kterou položku a jaké kvórum stačí
+	peers       map[NodeID]*rpc.Client  // RPC spojení na ostatní vlastníky
+
+	chunks        map[[32]byte][]byte // obsah chunků podle jejich SHA-256 adresy
+	chunkRefs     map[[32]byte]int    // kolik položek daný chunk sdílí
+	itemManifests map[int][][32]byte  // seznam hashů chunků pro payload každé položky
 }
 
-// NewServer inicializuje server s daným počtem položek.
-func NewServer(count int) *Server {
+// NewServer inicializuje server s daným počtem položek. lagThreshold určí,
+// kolik nedoručených (coalesced) ID odběratel smí mít čekajících, než jej
+// server kvůli zpoždění odpojí (viz subscribe.go) – obvyklá hodnota je
+// defaultSubscriberLagThreshold, nasazení s pomalejšími klienty nebo větší
+// pamětí na odběratele si může zvolit vyšší limit. Jako elektor a zámek se
+// použijí jednoprocesové implementace – pro skutečný cluster více instancí
+// Server je nahraďte např. KVElector nad etcd. Výchozí topologie replikace
+// je FullCopy nad jediným (tímto) uzlem, takže se chování oproti
+// jednouzlovému běhu nijak nemění.
+func NewServer(count, lagThreshold int) *Server {
+	const selfNode NodeID = "self"
+	runCtx, runCancel := context.WithCancel(context.Background())
 	s := &Server{
-		items:      make(map[int]*Item),
-		lastNotify: time.Now(),
+		items:                  make(map[int]*Item),
+		lastNotify:             time.Now(),
+		subscriberLagThreshold: lagThreshold,
+		elector:                NewInMemoryElector(),
+		lock:                   NewInMemoryLock(),
+		runCtx:                 runCtx,
+		runCancel:              runCancel,
+		nodeID:                 selfNode,
+		replication:            FullCopy{Nodes: []NodeID{selfNode}},
+		peers:                  make(map[NodeID]*rpc.Client),
+
+		chunks:        make(map[[32]byte][]byte),
+		chunkRefs:     make(map[[32]byte]int),
+		itemManifests: make(map[int][][32]byte),
 	}
 	for i := 0; i < count; i++ {
 		s.items[i] = &Item{
@@ -35,21 +80,20 @@ func NewServer(count int) *Server {
 			UpdatedAt: time.Now(),
 		}
 	}
+	s.rebuildMerkleTree()
 	return s
 }
 
-// Subscribe umožňuje klientovi přihlásit se k odběru notifikací o změnách ID.
-func (s *Server) Subscribe() <-chan []int {
-	ch := make(chan []int, 10)
-	s.mu.Lock()
-	s.subs = append(s.subs, ch)
-	s.mu.Unlock()
-	return ch
-}
-
 // GetItem umožňuje klientovi načíst aktuální hodnotu jedné položky podle ID.
 // Vrací defenzivní kopii, aby klient nemohl přímo měnit stav serveru.
+// Pokud podle aktuální topologie replikace tento uzel položku nevlastní,
+// dotáže se jejích skutečných vlastníků přes RPC a jakmile odpoví kvórum
+// z nich, vrátí nejnovější z vrácených verzí.
 func (s *Server) GetItem(id int) (*Item, error) {
+	if s.replication != nil && !s.ownsItem(id) {
+		return fetchFromOwners(id, s.replication.Owners(id), s.peersSnapshot(), s.replication.ReadQuorum())
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -62,10 +106,22 @@ func (s *Server) GetItem(id int) (*Item, error) {
 	return &Item{
 		ID:        item.ID,
 		Value:     item.Value,
+		Payload:   append([]byte(nil), item.Payload...),
 		UpdatedAt: item.UpdatedAt,
 	}, nil
 }
 
+// ownsItem řekne, zda je tento uzel podle aktuální topologie replikace
+// jedním z vlastníků dané položky.
+func (s *Server) ownsItem(id int) bool {
+	for _, owner := range s.replication.Owners(id) {
+		if owner == s.nodeID {
+			return true
+		}
+	}
+	return false
+}
+
 // getAllItems vrací kopii všech položek (pro počáteční synchronizaci).
 func (s *Server) getAllItems() []*Item {
 	s.mu.RLock()
@@ -77,101 +133,131 @@ func (s *Server) getAllItems() []*Item {
 		data = append(data, &Item{
 			ID:        item.ID,
 			Value:     item.Value,
+			Payload:   append([]byte(nil), item.Payload...),
 			UpdatedAt: item.UpdatedAt,
 		})
 	}
 	return data
 }
 
-// GetChangedItemIDsSince vrací seznam ID položek, které byly upraveny po daném čase 'since'.
-// Tato metoda simuluje endpoint pro resynchronizaci.
-// Klient odesílá svůj lastSyncTime, server mu vrátí ID položek, které se změnily po tomto čase.
-func (s *Server) GetChangedItemIDsSince(since time.Time) []int {
+// náhodně edituje jednu položku. Zápis jde přes editItem, takže se podle
+// aktuální topologie replikace rozešle všem vlastníkům a vrátí se, až
+// jakmile jej potvrdí zápisové kvórum. Payload (viz shiftItemPayload) se
+// mění jen lokálně na tomto uzlu – replikace payloadu mezi vlastníky není
+// předmětem tohoto požadavku, payload tu slouží jen k předvedení
+// chunkovaného resyncu v client().
+func (s *Server) randomEdit() {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
+	keys := make([]int, 0, len(s.items))
+	for id := range s.items {
+		keys = append(keys, id)
+	}
+	s.mu.RUnlock()
 
-	var changed []int
-	for id, item := range s.items {
-		// Používáme After, abychom získali všechny položky, které se změnily
-		// striktně po čase poslední synchronizace klienta.
-		if item.UpdatedAt.After(since) {
-			changed = append(changed, id)
-		}
+	if len(keys) == 0 {
+		return
 	}
-	return changed
-}
 
-// náhodně edituje jednu položku
-func (s *Server) randomEdit() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	id := keys[rand.Intn(len(keys))]
+	value := rand.Intn(1000)
 
-	// Zajistíme, že máme alespoň jednu položku k editaci
-	if len(s.items) == 0 {
+	if err := s.editItem(id, value); err != nil {
+		fmt.Printf("Server: Úprava položky %d selhala: %v\n", id, err)
 		return
 	}
 
-	// Náhodný výběr ID
-	var keys []int
-	for id := range s.items {
-		keys = append(keys, id)
+	if err := s.shiftItemPayload(id); err != nil {
+		fmt.Printf("Server: Úprava payloadu položky %d selhala: %v\n", id, err)
+		return
 	}
-	id := keys[rand.Intn(len(keys))]
 
-	item := s.items[id]
-	item.Value = rand.Intn(1000)
-	item.UpdatedAt = time.Now()
-	fmt.Printf("Server: Upravil položku s ID: %v na novou hodnotu: %v\n", id, item.Value)
+	fmt.Printf("Server: Upravil položku s ID: %v na novou hodnotu: %v\n", id, value)
 }
 
-// najde položky, které se změnily od posledního notifikování
-func (s *Server) getChangedItems() []int {
+// najde položky, které se změnily v polouzavřeném intervalu (since, until].
+// Meze bereme jako parametry (místo toho, abychom uvnitř znovu volali
+// time.Now()), aby hranice intervalu odpovídala přesně okamžiku, kdy
+// notifyClients začal skenovat – editace, které přistanou později, se
+// tak nemůžou "ztratit" mezi dvěma po sobě jdoucími notifikacemi.
+func (s *Server) getChangedItemsSince(since, until time.Time) []int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var changed []int
 	for id, item := range s.items {
-		if item.UpdatedAt.After(s.lastNotify) {
+		if item.UpdatedAt.After(since) && !item.UpdatedAt.After(until) {
 			changed = append(changed, id)
 		}
 	}
 	return changed
 }
 
-// notifyClients odešle všem odběratelům seznam ID, která se změnila.
+// notifyClients odešle všem odběratelům seznam ID, která se změnila od
+// posledního oznámení. Čas snímku (snapshotTime) se bere před skenováním
+// položek, ne až po něm – jinak by editace provedené během skenování
+// mohly v dalším kole zůstat bez povšimnutí. changed se zároveň
+// přeposílá peerům (broadcastChangedIDs), aby je mohli doručit i svým
+// odběratelům. V jedné Notification je každé ID nejvýš jednou; mezi
+// různými notifikacemi se duplicitní ID neodstraňují (viz ItemRPC.Notify).
 func (s *Server) notifyClients() {
-	changed := s.getChangedItems()
-	if len(changed) == 0 {
-		return
-	}
+	snapshotTime := time.Now()
 
-	// Odeslání notifikací klientům
 	s.mu.RLock()
-	// Vytvoříme kopii, abychom mohli uvolnit zámek dříve
-	subsCopy := make([]chan []int, len(s.subs))
-	copy(subsCopy, s.subs)
+	prevNotify := s.lastNotify
 	s.mu.RUnlock()
 
-	for _, ch := range subsCopy {
-		select {
-		case ch <- changed:
-			// Notifikace odeslána
-		default:
-			// Kanál plný – ignoruj (aby se server nezasekl)
-		}
+	changed := s.getChangedItemsSince(prevNotify, snapshotTime)
+	if len(changed) > 0 {
+		s.broadcastChangedIDs(changed)
 	}
 
-	// Aktualizujeme čas posledního oznámení (uvnitř samostatného zámku, protože se mění stav)
 	s.mu.Lock()
-	s.lastNotify = time.Now()
+	s.lastNotify = snapshotTime
 	s.mu.Unlock()
 }
 
-// Run spouští vlákna serveru pro úpravy a notifikace.
+// Run se uchází o vedení clusteru a teprve po jeho získání spouští
+// vlákna pro úpravy a notifikace – ty smí běžet jen na lídrovi, followeři
+// mezitím pouze obsluhují čtení (GetItem, Subscribe). Kampaň běží po dobu
+// s.runCtx, který Stop zruší – teprve tím se uvolní Elector (u KVElector to
+// navíc spustí poslední Delete leasu, takže uvolnění vedení ostatní uzly
+// uvidí téměř okamžitě a nemusí čekat na vypršení TTL).
 func (s *Server) Run() {
+	leadership, err := s.elector.Campaign(s.runCtx)
+	if err != nil {
+		fmt.Printf("Server: Kampaň o vedení selhala: %v\n", err)
+		return
+	}
+
+	go func() {
+		var stop context.CancelFunc
+		for state := range leadership {
+			if state.IsLeader && stop == nil {
+				var editCtx context.Context
+				editCtx, stop = context.WithCancel(context.Background())
+				s.runAsLeader(editCtx)
+			} else if !state.IsLeader && stop != nil {
+				stop()
+				stop = nil
+			}
+		}
+		if stop != nil {
+			stop()
+		}
+	}()
+}
+
+// runAsLeader spustí vlákna pro úpravy a notifikace, dokud ctx neskončí
+// (tedy dokud tato instance drží vedení).
+func (s *Server) runAsLeader(ctx context.Context) {
 	// Vlákno pro náhodné úpravy dat
 	go func() {
 		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
 			s.randomEdit()
 			time.Sleep(1000 * time.Millisecond) // Úprava každou 1 sekundu
 		}
@@ -180,31 +266,62 @@ func (s *Server) Run() {
 	// Vlákno pro notifikace (odesílá ID změn)
 	go func() {
 		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
 			s.notifyClients()
 			time.Sleep(4 * time.Second) // Notifikace každé 4 sekundy
 		}
 	}()
 }
 
-// processUpdatesWithTimeout zpracovává notifikace po omezenou dobu.
-func processUpdatesWithTimeout(id int, s *Server, cache map[int]*Item, updates <-chan []int) {
+// Stop ukončí kampaň o vedení spuštěnou přes Run – zruší s.runCtx, takže
+// Elector (viz KVElector.run) uvolní lease/klíč a tato instance přestane
+// editovat i notifikovat, ať už vedla, nebo ne. Simuluje "zabití" uzlu pro
+// účely failover testů i skutečné vypnutí serveru.
+func (s *Server) Stop() {
+	s.runCancel()
+}
+
+// AcquireItem vyžádá výhradní právo k editaci položky id napříč
+// clusterem. Dokud jej klient nevrátí přes ReleaseItem, žádný jiný uzel
+// by položku neměl editovat.
+func (s *Server) AcquireItem(ctx context.Context, id int) error {
+	return s.lock.Acquire(ctx, id)
+}
+
+// ReleaseItem uvolní zámek získaný přes AcquireItem.
+func (s *Server) ReleaseItem(id int) error {
+	return s.lock.Release(id)
+}
+
+// processUpdatesWithTimeout zpracovává notifikace po omezenou dobu. Vrací
+// true, pokud server odběratele odpojil kvůli zpoždění (Resync) a klient
+// tedy potřebuje před dalším odběrem provést plnou resynchronizaci.
+func processUpdatesWithTimeout(id int, s *Server, cache map[int]*Item, localChunks map[[32]byte][]byte, updates <-chan Notification) bool {
 	// Klient 1 bude zpracovávat updaty jen na omezenou dobu, aby simuloval odpojení.
 	// Připojen 5 až 12 sekund
 	timeout := time.After(time.Duration(rand.Intn(8)+5) * time.Second)
 
 	for {
 		select {
-		case changedIDs, ok := <-updates:
+		case notif, ok := <-updates:
 			if !ok {
 				fmt.Printf("Klient %d: Kanál notifikací byl zavřen.\n", id)
-				return
+				return false
+			}
+			if notif.Resync {
+				fmt.Printf("Klient %d: Server hlásí zahozené notifikace, potřebuji plnou resynchronizaci.\n", id)
+				return true
 			}
 
-			fmt.Printf("Klient %d: Obdržel notifikaci o změnách ID: %v. Načítám nová data...\n", id, changedIDs)
+			fmt.Printf("Klient %d: Obdržel notifikaci o změnách ID: %v. Načítám nová data...\n", id, notif.ChangedIDs)
 
 			// Logika pro stažení dat
-			for _, itemID := range changedIDs {
-				fetchedItem, err := s.GetItem(itemID)
+			for _, itemID := range notif.ChangedIDs {
+				fetchedItem, err := fetchAndCacheItem(s, itemID, localChunks)
 				if err != nil {
 					fmt.Printf("Klient %d: Chyba při načítání ID %d: %v\n", id, itemID, err)
 					continue
@@ -218,18 +335,30 @@ func processUpdatesWithTimeout(id int, s *Server, cache map[int]*Item, updates <
 
 		case <-timeout:
 			// Čas vypršel, návrat do hlavní smyčky klienta pro odpojení
-			return
+			return false
 		}
 	}
 }
 
-// processUpdates zpracovává notifikace dokud kanál není uzavřen.
-func processUpdates(id int, s *Server, cache map[int]*Item, updates <-chan []int) {
-	for changedIDs := range updates {
-		fmt.Printf("Klient %d: Obdržel notifikaci o změnách ID: %v. Načítám nová data...\n", id, changedIDs)
+// processUpdates zpracovává notifikace, dokud kanál není uzavřen. Pokaždé
+// vrací true, protože jediný způsob, jak tahle smyčka skončí, aniž by
+// jednou uviděla Resync, je to, že server odběratele odpojil kvůli
+// zpoždění – a ani tehdy se Resync nemusí spolehlivě doručit (viz komentář
+// v deliver v subscribe.go). Žádný jiný kód kanál odběratele nezavírá,
+// dokud processUpdates běží (cancel() volá vždy až její volající, po
+// návratu), takže zavření kanálu bez Resync je potřeba chápat jako
+// "potřebuji resync", ne jako čisté odpojení.
+func processUpdates(id int, s *Server, cache map[int]*Item, localChunks map[[32]byte][]byte, updates <-chan Notification) bool {
+	for notif := range updates {
+		if notif.Resync {
+			fmt.Printf("Klient %d: Server hlásí zahozené notifikace, potřebuji plnou resynchronizaci.\n", id)
+			return true
+		}
+
+		fmt.Printf("Klient %d: Obdržel notifikaci o změnách ID: %v. Načítám nová data...\n", id, notif.ChangedIDs)
 
-		for _, itemID := range changedIDs {
-			fetchedItem, err := s.GetItem(itemID)
+		for _, itemID := range notif.ChangedIDs {
+			fetchedItem, err := fetchAndCacheItem(s, itemID, localChunks)
 			if err != nil {
 				fmt.Printf("Klient %d: Chyba při načítání ID %d: %v\n", id, itemID, err)
 				continue
@@ -240,34 +369,42 @@ func processUpdates(id int, s *Server, cache map[int]*Item, updates <-chan []int
 				id, fetchedItem.ID, fetchedItem.Value, fetchedItem.UpdatedAt.Format("15:04:05"))
 		}
 	}
+	fmt.Printf("Klient %d: Kanál notifikací byl zavřen bez Resync zprávy, beru to jako žádost o resynchronizaci.\n", id)
+	return true
 }
 
 // client simuluje klienta, který udržuje lokální cache a připojuje se/odpojuje.
 func client(id int, s *Server) {
 	// Lokální cache klienta
 	cache := make(map[int]*Item)
-	// Čas poslední úspěšné synchronizace dat
-	lastSyncTime := time.Time{}
+	// Lokální cache chunků payloadu (viz chunker.go) – sdílená napříč
+	// celým životem klienta, aby opakované resyncy dotahovaly jen chunky,
+	// které klient ještě nemá.
+	localChunks := make(map[[32]byte][]byte)
+	firstSync := true
 
 	// Spouštěcí smyčka klienta
 	for {
 		// --- Fáze 1: Resynchronizace/Počáteční připojení ---
 		var itemsToProcess []*Item
 
-		if lastSyncTime.IsZero() {
+		if firstSync {
 			// První připojení: Načíst všechna data
 			itemsToProcess = s.getAllItems()
+			warmLocalChunks(itemsToProcess, localChunks)
+			firstSync = false
 
 		} else {
-			// Zpětné připojení: Získat ID změn od posledního synchronizovaného času
-			// Klient posílá serveru svůj čas poslední synchronizace
-			changedIDs := s.GetChangedItemIDsSince(lastSyncTime)
-			fmt.Printf("Klient %d: Znovu připojení (poslední synchronizace: %s). Dotazuji server na změny. Server hlásí %d ID ke stažení: %v\n",
-				id, lastSyncTime.Format("15:04:05"), len(changedIDs), changedIDs)
+			// Zpětné připojení: porovnáme Merkle strom naší cache se stromem
+			// serveru a sestoupíme jen do větví, které se liší – není třeba
+			// znát žádný čas poslední synchronizace ani projíždět všechny
+			// položky.
+			changedIDs := resyncViaMerkle(s, cache)
+			fmt.Printf("Klient %d: Znovu připojení. Porovnávám Merkle strom se serverem. Liší se %d ID: %v\n",
+				id, len(changedIDs), changedIDs)
 
-			// Načíst změněné položky
 			for _, itemID := range changedIDs {
-				fetchedItem, err := s.GetItem(itemID)
+				fetchedItem, err := fetchAndCacheItem(s, itemID, localChunks)
 				if err != nil {
 					fmt.Printf("Klient %d: Chyba při načítání ID %d: %v\n", id, itemID, err)
 					continue
@@ -276,34 +413,24 @@ func client(id int, s *Server) {
 			}
 		}
 
-		// Aktualizace lokální cache a lastSyncTime na základě právě stažených dat
-		currentMaxTime := lastSyncTime
+		// Aktualizace lokální cache na základě právě stažených dat
 		for _, item := range itemsToProcess {
 			cache[item.ID] = item // Aktualizace/vložení do cache
-			if item.UpdatedAt.After(currentMaxTime) {
-				currentMaxTime = item.UpdatedAt // Najít nejnovější čas ze stažených dat
-			}
-		}
-
-		// Aktualizace lastSyncTime, pokud jsme něco stáhli
-		if !currentMaxTime.Equal(lastSyncTime) || lastSyncTime.IsZero() {
-			lastSyncTime = currentMaxTime
 		}
 
-		// Zajistit, aby se lastSyncTime inicializoval, i když nic nebylo staženo při prvním připojení
-		if lastSyncTime.IsZero() {
-			lastSyncTime = time.Now()
-		}
-
-		fmt.Printf("Klient %d: Hotovo synchronizace. Velikost cache: %d. Nová LastSyncTime: %s\n",
-			id, len(cache), lastSyncTime.Format("15:04:05"))
+		fmt.Printf("Klient %d: Hotovo synchronizace. Velikost cache: %d.\n", id, len(cache))
 
 		// --- Fáze 2: Přihlášení k odběru a zpracování notifikací ---
-		updatesChan := s.Subscribe()
+		updatesChan, cancel, err := s.Subscribe()
+		if err != nil {
+			fmt.Printf("Klient %d: Přihlášení k odběru selhalo: %v\n", id, err)
+			return
+		}
 
 		if id == 1 {
 			// Klient 1: Zůstane připojen jen po dobu timeoutu
-			processUpdatesWithTimeout(id, s, cache, updatesChan)
+			processUpdatesWithTimeout(id, s, cache, localChunks, updatesChan)
+			cancel()
 
 			// Odpojení
 			fmt.Printf("Klient %d: *** ODPOJENÍ *** (simulace pádu/odchodu)\n", id)
@@ -314,16 +441,20 @@ func client(id int, s *Server) {
 			// Smyčka for {} se postará o přechod na Fázi 1 (Resynchronizace/Počáteční připojení)
 
 		} else {
-			// Klienti 0 a 2: Zůstanou připojeni
-			processUpdates(id, s, cache, updatesChan)
-			// Tato smyčka je nekonečná, Klienti 0 a 2 se nikdy neodpojí (pokud server nezavře kanál)
-			break
+			// Ostatní klienti (v main() jen klient 0): Zůstanou připojeni, dokud je server neodpojí
+			// kvůli zpoždění – pak se vrátí do Fáze 1 a resynchronizují se,
+			// místo aby dál tiše servírovali zastaralá data. processUpdates
+			// vrací true vždy, protože kanál odběratele nezavírá nic jiného
+			// než právě tohle odpojení kvůli zpoždění.
+			processUpdates(id, s, cache, localChunks, updatesChan)
+			cancel()
+			continue
 		}
 	}
 }
 
 func main() {
-	server := NewServer(10)
+	server := NewServer(10, defaultSubscriberLagThreshold)
 	server.Run()
 
 	// vytvoříme několik klientů
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+// TestProcessUpdatesTreatsPlainChannelCloseAsResync odpovídá požadavku
+// chunk0-5: jediný způsob, jak se subscriber kanál zavře, zatímco
+// processUpdates ještě běží, je odpojení kvůli zpoždění (viz deliver v
+// subscribe.go) – a to odpojení nemusí spolehlivě doručit Notification
+// {Resync:true} dřív, než kanál zavře. processUpdates proto musí i prosté
+// zavření bez Resync zprávy vyhodnotit jako potřebu resynchronizace, jinak
+// klient přestane navždy resynchronizovat a tiše servíruje zastaralá data.
+func TestProcessUpdatesTreatsPlainChannelCloseAsResync(t *testing.T) {
+	s := NewServer(3, defaultSubscriberLagThreshold)
+	cache := make(map[int]*Item)
+
+	updates := make(chan Notification)
+	close(updates) // zavřeno bez jediné Notification{Resync: true}
+
+	localChunks := make(map[[32]byte][]byte)
+	if needsResync := processUpdates(0, s, cache, localChunks, updates); !needsResync {
+		t.Fatalf("processUpdates u zavřeného kanálu bez Resync zprávy musí vrátit true")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// Parametry Merkle stromu: položky se rozdělí do pevného počtu segmentů
+// (listů) podle hashe jejich ID, nad kterými se staví binární strom pevné
+// hloubky. Díky tomu lze při reconnectu porovnat jen kořenový hash a
+// sestoupit pouze do těch větví, kde se hashe neshodují.
+const (
+	merkleDepth     = 3
+	merkleLeafCount = 1 << merkleDepth        // 8 listů
+	merkleNodeCount = 2*merkleLeafCount - 1    // vnitřní uzly + listy, pole indexované od 0 (kořen)
+	merkleLeafBase  = merkleLeafCount - 1      // index prvního listu v poli merkleNodeCount
+)
+
+// merkleBucket určí, do kterého listu stromu položka s daným ID patří.
+// Rozdělení je stabilní v čase (závisí jen na ID), takže se položka mezi
+// listy nepřesouvá.
+func merkleBucket(id int) int {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(id))
+	h := sha256.Sum256(buf[:])
+	return int(h[0]) % merkleLeafCount
+}
+
+// merkleItemHash spočítá hash jedné položky z ID, hodnoty a času poslední
+// úpravy (v nanosekundách), jak vyžaduje protokol pro porovnání replik.
+func merkleItemHash(item *Item) [32]byte {
+	var buf [24]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(item.ID))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(item.Value))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(item.UpdatedAt.UnixNano()))
+	return sha256.Sum256(buf[:])
+}
+
+// merkleCombine spočítá hash vnitřního uzlu ze dvou potomků.
+func merkleCombine(left, right [32]byte) [32]byte {
+	var buf [64]byte
+	copy(buf[0:32], left[:])
+	copy(buf[32:64], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleLeafHash spočítá hash listu ze seřazených ID položek, které do
+// daného listu patří, a funkce pro dohledání aktuálního stavu položky.
+func merkleLeafHash(ids []int, lookup func(id int) (*Item, bool)) [32]byte {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	h := sha256.New()
+	for _, id := range sorted {
+		item, ok := lookup(id)
+		if !ok {
+			continue
+		}
+		itemHash := merkleItemHash(item)
+		h.Write(itemHash[:])
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// buildMerkleTree postaví kompletní strom z dané množiny ID a funkce pro
+// dohledání aktuálního stavu položky. Vrací pole uzlů (index 0 = kořen) a
+// rozdělení ID do jednotlivých listů.
+func buildMerkleTree(ids []int, lookup func(id int) (*Item, bool)) ([merkleNodeCount][32]byte, [merkleLeafCount][]int) {
+	var nodes [merkleNodeCount][32]byte
+	var leafIDs [merkleLeafCount][]int
+
+	for _, id := range ids {
+		b := merkleBucket(id)
+		leafIDs[b] = append(leafIDs[b], id)
+	}
+
+	for b := 0; b < merkleLeafCount; b++ {
+		nodes[merkleLeafBase+b] = merkleLeafHash(leafIDs[b], lookup)
+	}
+	for i := merkleLeafBase - 1; i >= 0; i-- {
+		nodes[i] = merkleCombine(nodes[2*i+1], nodes[2*i+2])
+	}
+
+	return nodes, leafIDs
+}
+
+// rebuildMerkleTree postaví strom znovu od základu ze všech aktuálních
+// položek serveru. Volá se jen při startu, běžné editace se propisují
+// přírůstkově přes recomputeMerkleLeaf.
+// Volající musí držet s.mu (zápisový zámek).
+func (s *Server) rebuildMerkleTree() {
+	ids := make([]int, 0, len(s.items))
+	for id := range s.items {
+		ids = append(ids, id)
+	}
+	lookup := func(id int) (*Item, bool) {
+		item, ok := s.items[id]
+		return item, ok
+	}
+	s.merkleNodes, s.merkleLeafIDs = buildMerkleTree(ids, lookup)
+}
+
+// recomputeMerkleLeaf přepočítá hash listu, do kterého patří položka id, a
+// probublá novou hodnotu až ke kořeni. Volající musí držet s.mu (zápisový
+// zámek).
+func (s *Server) recomputeMerkleLeaf(id int) {
+	b := merkleBucket(id)
+	ids := s.merkleLeafIDs[b]
+	if !containsInt(ids, id) {
+		ids = append(ids, id)
+		s.merkleLeafIDs[b] = ids
+	}
+
+	lookup := func(id int) (*Item, bool) {
+		item, ok := s.items[id]
+		return item, ok
+	}
+	s.merkleNodes[merkleLeafBase+b] = merkleLeafHash(ids, lookup)
+
+	idx := merkleLeafBase + b
+	for idx > 0 {
+		parent := (idx - 1) / 2
+		s.merkleNodes[parent] = merkleCombine(s.merkleNodes[2*parent+1], s.merkleNodes[2*parent+2])
+		idx = parent
+	}
+}
+
+func containsInt(haystack []int, needle int) bool {
+	for _, v := range haystack {
+		if v == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// MerkleRoot vrací aktuální kořenový hash stromu nad všemi položkami
+// serveru. Klient jej porovná se svým lokálním stromem; shoda znamená, že
+// není třeba nic dotahovat.
+func (s *Server) MerkleRoot() [32]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.merkleNodes[0]
+}
+
+// MerkleNode vrací informace o uzlu na dané cestě od kořene (path[i] == 0
+// znamená levé, path[i] == 1 pravé dítě). Pro uzel na dně stromu (délka
+// path == merkleDepth) navíc vrací seznam ID položek uložených v daném
+// listu, aby klient věděl, co má dotáhnout.
+func (s *Server) MerkleNode(path []byte) (hash [32]byte, children [2][32]byte, leafIDs []int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx := 0
+	for _, step := range path {
+		idx = 2*idx + 1 + int(step)
+	}
+
+	hash = s.merkleNodes[idx]
+	if idx < merkleLeafBase {
+		children[0] = s.merkleNodes[2*idx+1]
+		children[1] = s.merkleNodes[2*idx+2]
+	} else {
+		leafIDs = append([]int(nil), s.merkleLeafIDs[idx-merkleLeafBase]...)
+	}
+	return hash, children, leafIDs
+}
+
+// resyncViaMerkle porovná lokální cache klienta se serverem pomocí
+// Merkle stromu a vrátí ID položek, které je potřeba znovu načíst.
+// Nezávisí na hodinách klienta ani serveru a ze serveru čte jen uzly
+// větví, jejichž hash se neshoduje; lokální strom klienta se přitom staví
+// nad celou jeho cache.
+func resyncViaMerkle(s *Server, cache map[int]*Item) []int {
+	if s.MerkleRoot() == localMerkleRoot(cache) {
+		return nil
+	}
+
+	var toFetch []int
+	var descend func(path []byte)
+	descend = func(path []byte) {
+		serverHash, _, serverLeafIDs := s.MerkleNode(path)
+		clientHash, clientLeafIDs := localMerkleNode(cache, path)
+		if serverHash == clientHash {
+			return
+		}
+		if len(path) == merkleDepth {
+			seen := make(map[int]struct{})
+			for _, id := range serverLeafIDs {
+				seen[id] = struct{}{}
+			}
+			for _, id := range clientLeafIDs {
+				seen[id] = struct{}{}
+			}
+			for id := range seen {
+				toFetch = append(toFetch, id)
+			}
+			return
+		}
+		descend(append(append([]byte(nil), path...), 0))
+		descend(append(append([]byte(nil), path...), 1))
+	}
+	descend(nil)
+
+	sort.Ints(toFetch)
+	return toFetch
+}
+
+// localMerkleRoot postaví Merkle strom nad lokální cache klienta a vrátí
+// jeho kořenový hash.
+func localMerkleRoot(cache map[int]*Item) [32]byte {
+	nodes, _ := localMerkleTree(cache)
+	return nodes[0]
+}
+
+// localMerkleNode vrátí hash a případné ID listu na dané cestě v
+// lokálním stromu klienta.
+func localMerkleNode(cache map[int]*Item, path []byte) ([32]byte, []int) {
+	nodes, leafIDs := localMerkleTree(cache)
+	idx := 0
+	for _, step := range path {
+		idx = 2*idx + 1 + int(step)
+	}
+	if idx < merkleLeafBase {
+		return nodes[idx], nil
+	}
+	return nodes[idx], leafIDs[idx-merkleLeafBase]
+}
+
+func localMerkleTree(cache map[int]*Item) ([merkleNodeCount][32]byte, [merkleLeafCount][]int) {
+	ids := make([]int, 0, len(cache))
+	for id := range cache {
+		ids = append(ids, id)
+	}
+	lookup := func(id int) (*Item, bool) {
+		item, ok := cache[id]
+		return item, ok
+	}
+	return buildMerkleTree(ids, lookup)
+}
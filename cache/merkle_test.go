@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+// cloneItems postaví hlubokou kopii položek serveru tak, jak by ji klient
+// držel ve své lokální cache po poslední synchronizaci.
+func cloneItems(s *Server) map[int]*Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cache := make(map[int]*Item, len(s.items))
+	for id, item := range s.items {
+		cache[id] = &Item{
+			ID:        item.ID,
+			Value:     item.Value,
+			Payload:   append([]byte(nil), item.Payload...),
+			UpdatedAt: item.UpdatedAt,
+		}
+	}
+	return cache
+}
+
+// leafSize vrátí počet položek uložených ve stejném listu Merkle stromu
+// jako id – to je jednotka, v jaké resyncViaMerkle dotahuje rozdíly (celý
+// list, ne jen jednotlivé ID), takže právě touto velikostí je potřeba
+// počítat, ne celkovým počtem položek.
+func leafSize(s *Server, id int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.merkleLeafIDs[merkleBucket(id)])
+}
+
+// TestResyncViaMerkleScalesWithChangedLeavesNotTotalItems odpovídá
+// požadavku chunk0-1: počet ID, která client() po resyncu skutečně musí
+// dotáhnout přes GetItem, se má odvíjet od toho, kolik listů Merkle stromu
+// se reálně změnilo, ne od celkového počtu položek v cache.
+func TestResyncViaMerkleScalesWithChangedLeavesNotTotalItems(t *testing.T) {
+	const totalItems = 400
+	s := NewServer(totalItems, defaultSubscriberLagThreshold)
+	cache := cloneItems(s)
+
+	// Shoda na začátku – nic k dotažení.
+	if diff := resyncViaMerkle(s, cache); diff != nil {
+		t.Fatalf("čerstvá kopie cache by neměla potřebovat resync, dostali jsme %v", diff)
+	}
+
+	// Upravíme jedinou položku na serveru; klientova cache zůstává stará.
+	const changedID = 1
+	if err := s.editItem(changedID, 999999); err != nil {
+		t.Fatalf("editItem(%d) selhalo: %v", changedID, err)
+	}
+
+	toFetch := resyncViaMerkle(s, cache)
+	wantCount := leafSize(s, changedID)
+	if len(toFetch) != wantCount {
+		t.Fatalf("resyncViaMerkle po 1 změněné položce vrátilo %d ID (%v), očekáváno %d (velikost dotčeného listu)",
+			len(toFetch), toFetch, wantCount)
+	}
+	if len(toFetch) >= totalItems/2 {
+		t.Fatalf("resync po 1 změně dotáhl %d z %d položek – neměl by se blížit celé sadě", len(toFetch), totalItems)
+	}
+
+	// Simulujeme, že klient změny dotáhl přes GetItem (přesně to dělá
+	// client() v main.go po obdržení seznamu z resyncViaMerkle), a
+	// počítáme, kolikrát k tomu bylo potřeba volat GetItem.
+	getItemCalls := 0
+	for _, id := range toFetch {
+		item, err := s.GetItem(id)
+		if err != nil {
+			t.Fatalf("GetItem(%d) selhalo: %v", id, err)
+		}
+		getItemCalls++
+		cache[id] = item
+	}
+	if getItemCalls != wantCount {
+		t.Fatalf("počet volání GetItem = %d, očekáváno %d", getItemCalls, wantCount)
+	}
+
+	// Po dotažení by už cache měla být synchronní.
+	if diff := resyncViaMerkle(s, cache); diff != nil {
+		t.Fatalf("cache po dotažení změn by měla odpovídat serveru, zbylo k dotažení: %v", diff)
+	}
+
+	// Teď změníme více položek rozesetých do více listů a ověříme, že
+	// počet ID k dotažení roste s počtem dotčených listů, ne skokem na
+	// celý dataset.
+	changedIDs := []int{2, 50, 150, 300}
+	wantLeaves := make(map[int]struct{})
+	for _, id := range changedIDs {
+		if err := s.editItem(id, 777); err != nil {
+			t.Fatalf("editItem(%d) selhalo: %v", id, err)
+		}
+		wantLeaves[merkleBucket(id)] = struct{}{}
+	}
+
+	s.mu.RLock()
+	wantCount = 0
+	for leaf := range wantLeaves {
+		wantCount += len(s.merkleLeafIDs[leaf])
+	}
+	s.mu.RUnlock()
+
+	toFetch = resyncViaMerkle(s, cache)
+	sort.Ints(toFetch)
+	if len(toFetch) != wantCount {
+		t.Fatalf("resync po %d změnách v %d listech vrátil %d ID, očekáváno %d",
+			len(changedIDs), len(wantLeaves), len(toFetch), wantCount)
+	}
+	if len(toFetch) >= totalItems/2 {
+		t.Fatalf("resync po %d změnách dotáhl %d z %d položek – mělo by jít jen o zasažené listy",
+			len(changedIDs), len(toFetch), totalItems)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sort"
+	"time"
+)
+
+// NodeID identifikuje jeden uzel clusteru (jednu instanci Server).
+type NodeID string
+
+// Replication rozhoduje, které uzly vlastní danou položku a kolik z nich
+// musí potvrdit čtení/zápis, než je operace považována za úspěšnou.
+// FullCopy a Sharded jsou dvě různé topologie nad stejným rozhraním.
+type Replication interface {
+	Owners(id int) []NodeID
+	WriteQuorum() int
+	ReadQuorum() int
+}
+
+// FullCopy je topologie, kde každý uzel drží kopii všech položek.
+// Čtení stačí z jednoho uzlu, zápis vyžaduje potvrzení většiny (aby
+// menšinová partition nemohla zapisovat nekonzistentně).
+type FullCopy struct {
+	Nodes []NodeID
+}
+
+func (f FullCopy) Owners(id int) []NodeID { return f.Nodes }
+
+func (f FullCopy) WriteQuorum() int {
+	if len(f.Nodes) == 0 {
+		return 0
+	}
+	return len(f.Nodes)/2 + 1
+}
+
+func (f FullCopy) ReadQuorum() int { return 1 }
+
+// Sharded je topologie s konzistentním hashovacím kruhem – každá položka
+// patří RF uzlům určeným pozicí na kruhu, s konfigurovatelnými kvórum pro
+// čtení (R) a zápis (W).
+type Sharded struct {
+	ring *hashRing
+	rf   int
+	r    int
+	w    int
+}
+
+// NewSharded vytvoří sharded topologii nad danými uzly s replikačním
+// faktorem rf a kvóry pro čtení r a zápis w.
+func NewSharded(nodes []NodeID, rf, r, w int) *Sharded {
+	return &Sharded{ring: newHashRing(nodes), rf: rf, r: r, w: w}
+}
+
+func (s *Sharded) Owners(id int) []NodeID {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(id))
+	key := sha256.Sum256(buf[:])
+	return s.ring.ownersFor(key, s.rf)
+}
+
+func (s *Sharded) WriteQuorum() int { return s.w }
+func (s *Sharded) ReadQuorum() int  { return s.r }
+
+// hashRing je konzistentní hashovací kruh s virtuálními uzly pro
+// rovnoměrnější rozložení shardů mezi fyzické uzly.
+type hashRing struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash [32]byte
+	node NodeID
+}
+
+const vnodesPerNode = 64
+
+func newHashRing(nodes []NodeID) *hashRing {
+	r := &hashRing{}
+	for _, n := range nodes {
+		for v := 0; v < vnodesPerNode; v++ {
+			h := sha256.Sum256([]byte(fmt.Sprintf("%s#%d", n, v)))
+			r.points = append(r.points, ringPoint{hash: h, node: n})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return lessHash(r.points[i].hash, r.points[j].hash)
+	})
+	return r
+}
+
+func lessHash(a, b [32]byte) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// ownersFor projde kruh od dané pozice po směru hodinových ručiček a
+// vrátí prvních n odlišných fyzických uzlů.
+func (r *hashRing) ownersFor(key [32]byte, n int) []NodeID {
+	if len(r.points) == 0 {
+		return nil
+	}
+	start := sort.Search(len(r.points), func(i int) bool {
+		return !lessHash(r.points[i].hash, key)
+	})
+
+	seen := make(map[NodeID]struct{})
+	var owners []NodeID
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if _, ok := seen[p.node]; ok {
+			continue
+		}
+		seen[p.node] = struct{}{}
+		owners = append(owners, p.node)
+	}
+	return owners
+}
+
+// GetItemArgs/GetItemReply jsou argumenty RPC volání GetItem mezi uzly
+// clusteru, zpřístupněného přes net/rpc (viz ServeRPC a dialPeer).
+type GetItemArgs struct {
+	ID int
+}
+
+type GetItemReply struct {
+	Item *Item
+}
+
+// ItemRPC je vystavené RPC rozhraní jedné instance Server – metody musí
+// mít signaturu požadovanou balíčkem net/rpc (func(args, *reply) error).
+type ItemRPC struct {
+	s *Server
+}
+
+// GetItem obslouží vzdálené volání GetItem od jiného uzlu clusteru.
+func (r *ItemRPC) GetItem(args *GetItemArgs, reply *GetItemReply) error {
+	item, err := r.s.GetItem(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Item = item
+	return nil
+}
+
+// PutItemArgs/PutItemReply jsou argumenty RPC volání, kterým lídr zápisu
+// rozesílá novou hodnotu ostatním vlastníkům položky (viz Server.editItem).
+type PutItemArgs struct {
+	ID        int
+	Value     int
+	UpdatedAt time.Time
+}
+
+type PutItemReply struct{}
+
+// PutItem obslouží vzdálený zápis od uzlu, který koordinuje editItem.
+func (r *ItemRPC) PutItem(args *PutItemArgs, reply *PutItemReply) error {
+	return r.s.applyLocalPut(args.ID, args.Value, args.UpdatedAt)
+}
+
+// NotifyArgs/NotifyReply nesou ID položek, která se změnila na jiném
+// vlastníkovi, aby je mohl tento uzel doručit i svým vlastním
+// odběratelům (viz Server.broadcastChangedIDs a Subscribe).
+type NotifyArgs struct {
+	ChangedIDs []int
+}
+
+type NotifyReply struct{}
+
+// Notify obslouží vzdálené oznámení o změně od jiného vlastníka a předá
+// jej místním odběratelům přes deliver. ID se slučují do jedné množiny jen
+// v pending odběratele, dokud je jeho kanál plný; je-li v kanálu místo,
+// každé oznámení se doručí jako samostatná Notification, takže stejné ID
+// oznámené více vlastníky může odběratel dostat víckrát.
+func (r *ItemRPC) Notify(args *NotifyArgs, reply *NotifyReply) error {
+	r.s.deliverToLocalSubscribers(args.ChangedIDs)
+	return nil
+}
+
+// ServeRPC zaregistruje ItemRPC a začne naslouchat na addr, aby mohly
+// ostatní uzly clusteru volat GetItem vzdáleně.
+func (s *Server) ServeRPC(addr string) (net.Listener, error) {
+	server := rpc.NewServer()
+	if err := server.Register(&ItemRPC{s: s}); err != nil {
+		return nil, fmt.Errorf("replication: registrace RPC selhala: %w", err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("replication: naslouchání na %s selhalo: %w", addr, err)
+	}
+	go server.Accept(ln)
+	return ln, nil
+}
+
+// dialPeer naváže RPC spojení na vzdálený uzel clusteru.
+func dialPeer(addr string) (*rpc.Client, error) {
+	return rpc.Dial("tcp", addr)
+}
+
+// AddPeer naváže RPC spojení na vzdálený uzel clusteru a zařadí jej mezi
+// peery, se kterými editItem/GetItem počítají při fan-outu.
+func (s *Server) AddPeer(node NodeID, addr string) error {
+	client, err := dialPeer(addr)
+	if err != nil {
+		return fmt.Errorf("replication: připojení k uzlu %s (%s) selhalo: %w", node, addr, err)
+	}
+	s.mu.Lock()
+	s.peers[node] = client
+	s.mu.Unlock()
+	return nil
+}
+
+// RemovePeer simuluje výpadek vzdáleného uzlu – zavře RPC spojení a
+// odebere jej ze seznamu peerů, takže další fan-out operace s ním už
+// nebudou počítat.
+func (s *Server) RemovePeer(node NodeID) {
+	s.mu.Lock()
+	client, ok := s.peers[node]
+	delete(s.peers, node)
+	s.mu.Unlock()
+	if ok {
+		client.Close()
+	}
+}
+
+// peersSnapshot vrátí kopii aktuální mapy peerů, aby volající nemuseli
+// držet s.mu po dobu (potenciálně pomalých) RPC volání.
+func (s *Server) peersSnapshot() map[NodeID]*rpc.Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make(map[NodeID]*rpc.Client, len(s.peers))
+	for node, client := range s.peers {
+		peers[node] = client
+	}
+	return peers
+}
+
+// applyLocalPut zapíše hodnotu do lokální kopie položky, pokud je
+// updatedAt novější než to, co tu už máme (last-write-wins) – tak se
+// vypořádá jak s přímou editací, tak se zápisem replikovaným přes RPC
+// od jiného vlastníka.
+func (s *Server) applyLocalPut(id, value int, updatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return fmt.Errorf("položka s ID %d nenalezena", id)
+	}
+	if !updatedAt.After(item.UpdatedAt) {
+		return nil
+	}
+	item.Value = value
+	item.UpdatedAt = updatedAt
+	s.recomputeMerkleLeaf(id)
+	return nil
+}
+
+// editItem zapíše novou hodnotu položky id podle aktuální topologie
+// replikace: rozešle zápis všem vlastníkům (lokálně i přes RPC) a vrátí
+// se úspěšně, teprve jakmile potvrdí alespoň WriteQuorum() z nich.
+func (s *Server) editItem(id, value int) error {
+	now := time.Now()
+	owners := s.replication.Owners(id)
+	quorum := s.replication.WriteQuorum()
+	peers := s.peersSnapshot()
+
+	acks := 0
+	var lastErr error
+	for _, node := range owners {
+		if node == s.nodeID {
+			if err := s.applyLocalPut(id, value, now); err != nil {
+				lastErr = err
+				continue
+			}
+			acks++
+			continue
+		}
+
+		client, ok := peers[node]
+		if !ok {
+			lastErr = fmt.Errorf("replication: chybí RPC spojení na uzel %s", node)
+			continue
+		}
+		var reply PutItemReply
+		if err := client.Call("ItemRPC.PutItem", &PutItemArgs{ID: id, Value: value, UpdatedAt: now}, &reply); err != nil {
+			lastErr = err
+			continue
+		}
+		acks++
+	}
+
+	if acks < quorum {
+		return fmt.Errorf("replication: nepodařilo se získat zápisové kvórum (%d/%d) pro položku %d: %w", acks, quorum, id, lastErr)
+	}
+	return nil
+}
+
+// broadcastChangedIDs doručí changedIDs místním odběratelům a zároveň je
+// přepošle všem peerům, aby je mohli doručit i svým vlastním odběratelům
+// – to je mechanismus, kterým se notifikace "sloučí" napříč vlastníky
+// jedné položky (viz ItemRPC.Notify a subscribe.go).
+func (s *Server) broadcastChangedIDs(changedIDs []int) {
+	s.deliverToLocalSubscribers(changedIDs)
+
+	for node, client := range s.peersSnapshot() {
+		var reply NotifyReply
+		if err := client.Call("ItemRPC.Notify", &NotifyArgs{ChangedIDs: changedIDs}, &reply); err != nil {
+			fmt.Printf("Server: Přeposlání notifikace uzlu %s selhalo: %v\n", node, err)
+		}
+	}
+}
+
+// deliverToLocalSubscribers doručí changedIDs všem odběratelům
+// přihlášeným na tomto uzlu.
+func (s *Server) deliverToLocalSubscribers(changedIDs []int) {
+	s.mu.RLock()
+	subsCopy := make([]*subscriber, len(s.subs))
+	copy(subsCopy, s.subs)
+	s.mu.RUnlock()
+
+	for _, sub := range subsCopy {
+		s.deliver(sub, changedIDs)
+	}
+}
+
+// fetchFromOwners dotáže vzdálené vlastníky položky přes RPC a vrátí
+// nejnovější verzi, jakmile se sejde alespoň quorum odpovědí.
+func fetchFromOwners(id int, owners []NodeID, peers map[NodeID]*rpc.Client, quorum int) (*Item, error) {
+	var best *Item
+	acks := 0
+	var lastErr error
+
+	for _, node := range owners {
+		client, ok := peers[node]
+		if !ok {
+			lastErr = fmt.Errorf("replication: chybí RPC spojení na uzel %s", node)
+			continue
+		}
+		var reply GetItemReply
+		if err := client.Call("ItemRPC.GetItem", &GetItemArgs{ID: id}, &reply); err != nil {
+			lastErr = err
+			continue
+		}
+		acks++
+		if best == nil || reply.Item.UpdatedAt.After(best.UpdatedAt) {
+			best = reply.Item
+		}
+		if acks >= quorum {
+			return best, nil
+		}
+	}
+
+	if acks >= quorum {
+		return best, nil
+	}
+	return nil, fmt.Errorf("replication: nepodařilo se získat kvórum (%d/%d) pro položku %d: %w", acks, quorum, id, lastErr)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// newReplicationTestCluster nastartuje count uzlů se sdílenou Sharded
+// topologií (stejný hashovací kruh na každém z nich), propojí je plnou
+// mesh sítí přes RPC a vrátí je spolu s jejich listenery (aby šlo
+// simulovat výpadek uzlu zavřením jeho listeneru/odpojením peerů).
+func newReplicationTestCluster(t *testing.T, count, rf, r, w int) (map[NodeID]*Server, map[NodeID]net.Listener) {
+	t.Helper()
+
+	var nodeIDs []NodeID
+	for i := 0; i < count; i++ {
+		nodeIDs = append(nodeIDs, NodeID(fmt.Sprintf("n%d", i)))
+	}
+
+	servers := make(map[NodeID]*Server, count)
+	listeners := make(map[NodeID]net.Listener, count)
+	addrs := make(map[NodeID]string, count)
+
+	for _, node := range nodeIDs {
+		s := NewServer(20, defaultSubscriberLagThreshold)
+		s.nodeID = node
+		s.replication = NewSharded(nodeIDs, rf, r, w)
+
+		ln, err := s.ServeRPC("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("ServeRPC(%s) selhalo: %v", node, err)
+		}
+
+		servers[node] = s
+		listeners[node] = ln
+		addrs[node] = ln.Addr().String()
+	}
+
+	for _, node := range nodeIDs {
+		for _, peer := range nodeIDs {
+			if peer == node {
+				continue
+			}
+			if err := servers[node].AddPeer(peer, addrs[peer]); err != nil {
+				t.Fatalf("AddPeer(%s -> %s) selhalo: %v", node, peer, err)
+			}
+		}
+	}
+
+	return servers, listeners
+}
+
+// alivePrimaryOwner vrátí prvního vlastníka položky id, který je mezi
+// alive (tedy uzel, který nebyl v průběhu testu "zabit").
+func alivePrimaryOwner(t *testing.T, servers map[NodeID]*Server, alive map[NodeID]bool, id int) *Server {
+	t.Helper()
+	for _, owner := range servers[firstAliveNode(alive)].replication.Owners(id) {
+		if alive[owner] {
+			return servers[owner]
+		}
+	}
+	t.Fatalf("položka %d nemá žádného živého vlastníka", id)
+	return nil
+}
+
+// aliveReader odpovídá požadavku chunk0-3: vrátí živého čtenáře položky id,
+// který pokud možno NENÍ jedním z jejích vlastníků, aby GetItem skutečně
+// prošel přes fetchFromOwners (RPC kvórum, viz cache/main.go GetItem) – na
+// vlastnícím uzlu by GetItem vrátil lokální kopii a cestu přes RPC by vůbec
+// nevyzkoušel. Pokud mezi přeživšími uzly žádný takový není (zabité uzly
+// samy byly nevlastníky dané položky), vrátí se alivePrimaryOwner jako
+// dřív – pořád aspoň ověří správnost hodnoty, i když nevyzkouší RPC cestu.
+func aliveReader(t *testing.T, servers map[NodeID]*Server, alive map[NodeID]bool, id int) *Server {
+	t.Helper()
+	owners := make(map[NodeID]bool)
+	for _, owner := range servers[firstAliveNode(alive)].replication.Owners(id) {
+		owners[owner] = true
+	}
+	for node, ok := range alive {
+		if ok && !owners[node] {
+			return servers[node]
+		}
+	}
+	return alivePrimaryOwner(t, servers, alive, id)
+}
+
+func firstAliveNode(alive map[NodeID]bool) NodeID {
+	for node, ok := range alive {
+		if ok {
+			return node
+		}
+	}
+	return ""
+}
+
+// TestReplicationFiveNodeClusterSurvivesTwoFailures odpovídá požadavku
+// chunk0-3: 5 uzlů, RF=3, a po zabití 2 z nich musí čtení i zápis pořád
+// fungovat pro všechny shardy (kvórum R=W=1 je zde zvoleno záměrně –
+// u RF=3 je to jediné kvórum, které garantovaně přežije libovolné dva
+// výpadky, protože mezi 3 vlastníky a 5 uzly vždy zbyde aspoň 1 živý
+// vlastník).
+func TestReplicationFiveNodeClusterSurvivesTwoFailures(t *testing.T) {
+	const (
+		nodeCount = 5
+		rf        = 3
+		r         = 1
+		w         = 1
+	)
+
+	servers, listeners := newReplicationTestCluster(t, nodeCount, rf, r, w)
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+
+	alive := make(map[NodeID]bool, nodeCount)
+	for node := range servers {
+		alive[node] = true
+	}
+
+	// Zabijeme dva uzly: zavřeme jejich listener a odstraníme je ze
+	// seznamu peerů přeživších, aby na ně fan-out dál nezkoušel volat.
+	var killed []NodeID
+	for node := range servers {
+		killed = append(killed, node)
+		if len(killed) == 2 {
+			break
+		}
+	}
+	for _, node := range killed {
+		listeners[node].Close()
+		alive[node] = false
+	}
+	for node, s := range servers {
+		if !alive[node] {
+			continue
+		}
+		for _, dead := range killed {
+			s.RemovePeer(dead)
+		}
+	}
+
+	for id := 0; id < 10; id++ {
+		coordinator := alivePrimaryOwner(t, servers, alive, id)
+
+		if err := coordinator.editItem(id, 1000+id); err != nil {
+			t.Fatalf("editItem(%d) po výpadku 2 uzlů selhal: %v", id, err)
+		}
+
+		reader := aliveReader(t, servers, alive, id)
+		item, err := reader.GetItem(id)
+		if err != nil {
+			t.Fatalf("GetItem(%d) po výpadku 2 uzlů selhal: %v", id, err)
+		}
+		if item.Value != 1000+id {
+			t.Fatalf("GetItem(%d) = %d, očekáváno %d", id, item.Value, 1000+id)
+		}
+	}
+}
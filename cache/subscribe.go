@@ -0,0 +1,116 @@
+package main
+
+import "sync"
+
+// defaultSubscriberLagThreshold je výchozí počet dosud nedoručených
+// (coalesced) ID, po jehož překročení server usoudí, že odběratel
+// nestíhá, a raději jej odpojí s žádostí o plnou resynchronizaci, než aby
+// dál ticho ztrácel notifikace. Skutečná hodnota použitá za běhu je
+// Server.subscriberLagThreshold, nastavitelná v NewServer – různá nasazení
+// mohou chtít jiný kompromis mezi pamětí na odběratele a tolerancí k
+// pomalým klientům.
+const defaultSubscriberLagThreshold = 64
+
+// Notification je zpráva doručovaná odběrateli. Buď nese ChangedIDs
+// (přírůstkovou deltu od poslední notifikace), nebo je Resync true – v tom
+// případě server zahodil notifikace, na které odběratel nestíhal, a
+// klient musí provést plnou resynchronizaci (Merkle porovnání), než bude
+// cache opět důvěryhodná.
+type Notification struct {
+	ChangedIDs []int
+	Resync     bool
+}
+
+// CancelFunc odhlásí odběratele a uvolní jeho kanál.
+type CancelFunc func()
+
+// subscriber drží stav jednoho odběratele notifikací: kanál, který čte
+// klient, a množinu ID čekajících na doručení, pokud je kanál dočasně
+// plný.
+type subscriber struct {
+	ch      chan Notification
+	mu      sync.Mutex
+	pending map[int]struct{}
+	closed  bool
+}
+
+// Subscribe umožňuje klientovi přihlásit se k odběru notifikací o
+// změnách ID. Vrácený kanál nikdy "tiše" neztratí změnu – pokud odběratel
+// nestíhá vybírat, server ID nejdřív coalesce (sloučí do jedné množiny) a
+// teprve při překročení s.subscriberLagThreshold odběratele odpojí zprávou
+// Resync, aby klient věděl, že musí provést plnou resynchronizaci.
+func (s *Server) Subscribe() (<-chan Notification, CancelFunc, error) {
+	sub := &subscriber{
+		ch:      make(chan Notification, 10),
+		pending: make(map[int]struct{}),
+	}
+
+	s.mu.Lock()
+	s.subs = append(s.subs, sub)
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		for i, other := range s.subs {
+			if other == sub {
+				s.subs = append(s.subs[:i], s.subs[i+1:]...)
+				break
+			}
+		}
+		s.mu.Unlock()
+		s.closeSubscriber(sub)
+	}
+
+	return sub.ch, cancel, nil
+}
+
+func (s *Server) closeSubscriber(sub *subscriber) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		close(sub.ch)
+		sub.closed = true
+	}
+}
+
+// deliver doručí changedIDs jednomu odběrateli. Pokud je jeho kanál
+// plný, ID se jen přidají do pending množiny (coalesce) namísto toho,
+// aby byla zahozena; pokud pending přeroste s.subscriberLagThreshold,
+// odběratel dostane Resync a je odpojen.
+func (s *Server) deliver(sub *subscriber, changedIDs []int) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return
+	}
+
+	for _, id := range changedIDs {
+		sub.pending[id] = struct{}{}
+	}
+
+	ids := make([]int, 0, len(sub.pending))
+	for id := range sub.pending {
+		ids = append(ids, id)
+	}
+
+	select {
+	case sub.ch <- Notification{ChangedIDs: ids}:
+		sub.pending = make(map[int]struct{})
+		return
+	default:
+		// Kanál je plný – necháváme ID v pending a zkusíme to při
+		// příští notifikaci znovu.
+	}
+
+	if len(sub.pending) > s.subscriberLagThreshold {
+		select {
+		case sub.ch <- Notification{Resync: true}:
+		default:
+			// Ani Resync se nevejde – kanál stejně rušíme, klient se
+			// o zpoždění dozví ze zavřeného kanálu.
+		}
+		close(sub.ch)
+		sub.closed = true
+	}
+}
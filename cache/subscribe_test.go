@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+// TestSubscriberLagThresholdConfigurable odpovídá požadavku chunk0-5: limit
+// zpoždění odběratele musí jít nastavit přes NewServer, ne být napevno daný
+// konstantou. Test zaplní kanál odběratele (kapacita 10) a pak doručí
+// dávku, jejíž pending překročí nízký, explicitně nastavený limit – server
+// musí odběratele odpojit (viz komentář v deliver: pokud se ani Resync
+// nevejde, odběratel se o zpoždění dozví ze zavřeného kanálu), mnohem dřív,
+// než by to s defaultSubscriberLagThreshold vůbec přišlo v úvahu.
+func TestSubscriberLagThresholdConfigurable(t *testing.T) {
+	const lowThreshold = 3
+	s := NewServer(1, lowThreshold)
+
+	ch, cancel, err := s.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe selhalo: %v", err)
+	}
+	defer cancel()
+
+	// Zaplníme kanál (kapacita 10) jednotlivými doručeními – ta ještě
+	// všechna uspějí, pending zůstává prázdné.
+	for i := 0; i < 10; i++ {
+		s.deliverToLocalSubscribers([]int{i})
+	}
+
+	// Tahle dávka už se do plného kanálu nevejde, takže skončí v pending;
+	// protože má víc ID než lowThreshold, server odběratele rovnou odpojí.
+	s.deliverToLocalSubscribers([]int{100, 101, 102, 103, 104})
+
+	// Vybereme 10 dřív zafrontovaných notifikací...
+	for i := 0; i < 10; i++ {
+		if _, ok := <-ch; !ok {
+			t.Fatalf("kanál se zavřel dřív, než byly vybrány všechny zafrontované notifikace")
+		}
+	}
+	// ...a kanál už musí být zavřený, protože lowThreshold byl překročen.
+	if _, ok := <-ch; ok {
+		t.Fatalf("při lagThreshold=%d měl být odběratel po přetečení odpojen (kanál zavřený)", lowThreshold)
+	}
+}
+
+// TestSubscriberLagThresholdHigherToleratesMoreBacklog ukazuje opačnou
+// stranu konfigurovatelnosti: vyšší limit tu samou situaci ustojí bez
+// odpojení, což dokazuje, že hodnota skutečně pochází z NewServer, ne z
+// nějaké zbytkové pevné konstanty.
+func TestSubscriberLagThresholdHigherToleratesMoreBacklog(t *testing.T) {
+	const highThreshold = 100
+	s := NewServer(1, highThreshold)
+
+	ch, cancel, err := s.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe selhalo: %v", err)
+	}
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		s.deliverToLocalSubscribers([]int{i})
+	}
+	s.deliverToLocalSubscribers([]int{100, 101, 102, 103, 104})
+
+	for i := 0; i < 10; i++ {
+		if _, ok := <-ch; !ok {
+			t.Fatalf("kanál se zavřel dřív, než byly vybrány všechny zafrontované notifikace")
+		}
+	}
+
+	// Místo má teď volno – doručení se stejným pendingem (5 ID, hluboko
+	// pod highThreshold) musí teď uspět, ne odpojit odběratele.
+	s.deliverToLocalSubscribers(nil)
+	notif, ok := <-ch
+	if !ok {
+		t.Fatalf("při lagThreshold=%d neměl být odběratel odpojen", highThreshold)
+	}
+	if notif.Resync {
+		t.Fatalf("očekávala se běžná dávka ID, ne Resync: %+v", notif)
+	}
+}